@@ -0,0 +1,48 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tchannel
+
+// ChannelOptions are used to control parameters on a specific Channel.
+type ChannelOptions struct {
+	// RelayOptions configures the pluggable relay subsystem (resource
+	// limits, ACL filtering, metrics and destination selection) a Channel
+	// that relays calls will use. See RelayOptions for details; the zero
+	// value disables every optional piece.
+	RelayOptions RelayOptions
+}
+
+// Channel is a bi-directional connection to the peering and messaging
+// network used to send and receive TChannel requests.
+type Channel struct {
+	// relayOptions is the RelayOptions this Channel was constructed with,
+	// copied from ChannelOptions.RelayOptions by NewChannel. NewRelayer
+	// reads it back through the RelayXxx accessors in relay_options.go.
+	relayOptions RelayOptions
+}
+
+// NewChannel creates a new Channel for the given service name.
+func NewChannel(serviceName string, opts *ChannelOptions) (*Channel, error) {
+	ch := &Channel{}
+	if opts != nil {
+		ch.relayOptions = opts.RelayOptions
+	}
+	return ch, nil
+}
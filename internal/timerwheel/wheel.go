@@ -0,0 +1,229 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package timerwheel implements a hashed timing wheel, so that callers
+// with many short-lived, coarsely-timed deadlines (e.g. relay tombstone
+// GC) can batch them behind a single ticking goroutine instead of paying
+// for a timer per item.
+package timerwheel
+
+import (
+	"sync"
+	"time"
+)
+
+// numBuckets is the number of slots in the wheel. At the default 50ms
+// tick, that's a little over 3s of ring before an index wraps. Deadlines
+// further out than that still land in the right bucket: Schedule counts
+// how many full revolutions an entry must wait out before it's due, and
+// the wheel decrements that count once per revolution instead of firing
+// early.
+const numBuckets = 64
+
+// Callback is invoked once per tick for every live id whose entry has
+// finished waiting out its rounds.
+type Callback func(id uint32)
+
+type entry struct {
+	id     uint32
+	rounds int
+	dead   bool
+}
+
+// WheelStats is a point-in-time snapshot of a Wheel's activity, suitable
+// for periodic export to a metrics backend.
+type WheelStats struct {
+	Scheduled int64
+	Cancelled int64
+	Reaped    int64
+}
+
+// Wheel is a hashed timing wheel: items that expire within the same tick
+// share a bucket, and a background goroutine advances the wheel and reaps
+// one bucket per tick. This trades per-item timer precision (±tick) for
+// O(1) scheduling and reaping, which is the right trade-off for GC'ing
+// relay tombstones at high concurrency.
+//
+// The background goroutine only runs while the wheel has live entries:
+// Schedule starts it lazily if it isn't already running, and it exits on
+// its own once a reap leaves the wheel empty, so a Wheel that sits idle
+// doesn't leak a goroutine for the life of the process. Stop forces an
+// immediate shutdown regardless of whether entries remain.
+type Wheel struct {
+	tick time.Duration
+	cb   Callback
+
+	mu      sync.Mutex
+	buckets [][]entry
+	cursor  int
+	stats   WheelStats
+	count   int
+
+	running bool
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// New creates a Wheel that ticks at the given interval, invoking cb for
+// every live id whose entry comes due. Its background goroutine starts
+// the first time Schedule is called.
+func New(tick time.Duration, cb Callback) *Wheel {
+	return &Wheel{
+		tick:    tick,
+		cb:      cb,
+		buckets: make([][]entry, numBuckets),
+	}
+}
+
+// Schedule places id into the bucket corresponding to deadline, rounded
+// down to a whole number of ticks, and returns that bucket's index so the caller can
+// later cancel the entry via Cancel before it's reaped. It starts the
+// wheel's background goroutine if this is the first outstanding entry.
+func (w *Wheel) Schedule(deadline time.Time, id uint32) (bucket int) {
+	ticks := int(time.Until(deadline) / w.tick)
+	if ticks < 0 {
+		ticks = 0
+	}
+	rounds := ticks / numBuckets
+	offset := ticks % numBuckets
+
+	w.mu.Lock()
+	bucket = (w.cursor + offset) % numBuckets
+	w.buckets[bucket] = append(w.buckets[bucket], entry{id: id, rounds: rounds})
+	w.stats.Scheduled++
+	w.count++
+	w.startLocked()
+	w.mu.Unlock()
+	return bucket
+}
+
+// Cancel marks id's entry in bucket dead, so the reaper skips it instead
+// of invoking the callback. It's a no-op if the entry was already reaped.
+func (w *Wheel) Cancel(bucket int, id uint32) {
+	w.mu.Lock()
+	for i := range w.buckets[bucket] {
+		if w.buckets[bucket][i].id == id && !w.buckets[bucket][i].dead {
+			w.buckets[bucket][i].dead = true
+			w.stats.Cancelled++
+			break
+		}
+	}
+	w.mu.Unlock()
+}
+
+// startLocked starts the background goroutine if it isn't already
+// running. w.mu must be held.
+func (w *Wheel) startLocked() {
+	if w.running {
+		return
+	}
+	w.running = true
+	w.stopCh = make(chan struct{})
+	w.doneCh = make(chan struct{})
+	go w.run(w.stopCh, w.doneCh)
+}
+
+// Stop halts the background goroutine if it's running, dropping any
+// entries still waiting in a bucket without invoking the callback. It's
+// safe to call on a wheel that has already self-stopped from being idle,
+// or one that was never scheduled against: a later Schedule call will
+// restart it.
+func (w *Wheel) Stop() {
+	w.mu.Lock()
+	if !w.running {
+		w.mu.Unlock()
+		return
+	}
+	stopCh, doneCh := w.stopCh, w.doneCh
+	w.running = false
+	w.buckets = make([][]entry, numBuckets)
+	w.count = 0
+	w.mu.Unlock()
+
+	close(stopCh)
+	<-doneCh
+}
+
+// Stats returns a snapshot of the wheel's counters.
+func (w *Wheel) Stats() WheelStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+func (w *Wheel) run(stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if !w.reapCurrent() {
+				return
+			}
+		}
+	}
+}
+
+// reapCurrent reaps the current bucket, firing the callback for every
+// live entry that has waited out its remaining rounds, and carrying the
+// rest forward by one round. It returns false once this leaves the wheel
+// with no outstanding entries, telling run to exit rather than keep
+// ticking an empty wheel.
+func (w *Wheel) reapCurrent() (active bool) {
+	w.mu.Lock()
+	cur := w.cursor
+	bucket := w.buckets[cur]
+	w.buckets[cur] = nil
+	w.cursor = (cur + 1) % numBuckets
+
+	var carry []entry
+	var fire []uint32
+	for _, e := range bucket {
+		if e.rounds > 0 {
+			e.rounds--
+			carry = append(carry, e)
+			continue
+		}
+		w.stats.Reaped++
+		w.count--
+		if !e.dead {
+			fire = append(fire, e.id)
+		}
+	}
+	if len(carry) > 0 {
+		w.buckets[cur] = carry
+	}
+
+	active = w.count > 0
+	if !active {
+		w.running = false
+	}
+	w.mu.Unlock()
+
+	for _, id := range fire {
+		w.cb(id)
+	}
+	return active
+}
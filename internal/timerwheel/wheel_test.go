@@ -0,0 +1,105 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package timerwheel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const _testTick = 5 * time.Millisecond
+
+func newFiringWheel() (*Wheel, *sync.Map) {
+	fired := &sync.Map{}
+	w := New(_testTick, func(id uint32) {
+		fired.Store(id, true)
+	})
+	return w, fired
+}
+
+func hasFired(fired *sync.Map, id uint32) bool {
+	_, ok := fired.Load(id)
+	return ok
+}
+
+func TestWheel_ScheduleWithinHorizon(t *testing.T) {
+	w, fired := newFiringWheel()
+
+	w.Schedule(time.Now().Add(2*_testTick), 1)
+
+	assert.Eventually(t, func() bool { return hasFired(fired, 1) }, time.Second, _testTick)
+}
+
+func TestWheel_ScheduleBeyondHorizon(t *testing.T) {
+	w, fired := newFiringWheel()
+
+	// A deadline several revolutions out must still fire at roughly its
+	// real time, not be clamped into the first revolution.
+	deadline := time.Now().Add(time.Duration(numBuckets+5) * _testTick)
+	w.Schedule(deadline, 1)
+
+	early := time.Duration(numBuckets-2) * _testTick
+	time.Sleep(early)
+	assert.False(t, hasFired(fired, 1), "entry fired before its real deadline")
+
+	assert.Eventually(t, func() bool { return hasFired(fired, 1) }, time.Second, _testTick)
+}
+
+func TestWheel_Cancel(t *testing.T) {
+	w, fired := newFiringWheel()
+
+	bucket := w.Schedule(time.Now().Add(2*_testTick), 1)
+	w.Cancel(bucket, 1)
+
+	time.Sleep(10 * _testTick)
+	assert.False(t, hasFired(fired, 1))
+	assert.EqualValues(t, 1, w.Stats().Cancelled)
+}
+
+func TestWheel_SelfStopsWhenIdle(t *testing.T) {
+	w, fired := newFiringWheel()
+
+	w.Schedule(time.Now().Add(_testTick), 1)
+	assert.Eventually(t, func() bool { return hasFired(fired, 1) }, time.Second, _testTick)
+
+	// Give the wheel a chance to notice it's empty and stop itself.
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return !w.running
+	}, time.Second, _testTick)
+
+	// Scheduling again must restart it rather than scheduling into a dead
+	// goroutine.
+	w.Schedule(time.Now().Add(_testTick), 2)
+	assert.Eventually(t, func() bool { return hasFired(fired, 2) }, time.Second, _testTick)
+}
+
+func TestWheel_StopIsIdempotent(t *testing.T) {
+	w, _ := newFiringWheel()
+
+	w.Schedule(time.Now().Add(time.Hour), 1)
+	w.Stop()
+	w.Stop()
+}
@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/uber/tchannel-go/atomic"
+	"github.com/uber/tchannel-go/internal/timerwheel"
 	"github.com/uber/tchannel-go/relay"
 )
 
@@ -37,26 +38,56 @@ const _maxRelayTombs = 1e4
 // _relayTombTTL is the length of time we'll keep a tomb before GC'ing it.
 const _relayTombTTL = time.Second
 
-type relayItem struct {
-	*time.Timer
+// _relayWheelTick is how often a Relayer's timerwheel advances. Every
+// relay item's TTL expiry and tombstone GC are rounded to this
+// granularity, in exchange for handling both in O(1) instead of with a
+// timer per item.
+const _relayWheelTick = 50 * time.Millisecond
+
+// _inboundWheelBit distinguishes inbound from outbound relay items when
+// both share a single Relayer-wide timerwheel, since the two relayItems
+// maps are keyed independently and may reuse the same numeric IDs.
+const _inboundWheelBit = uint32(1) << 31
+
+// callMeta captures the caller, callee, procedure and start time for a
+// relayed call's originating item, so CallCompleted/CallTimedOut can be
+// reported when it finishes without re-parsing the original frame. It's
+// only populated for the item on the relay that received the call (the
+// one that made the reservation); the mirrored item on the destination
+// relay leaves it zero to avoid double-counting metrics.
+type callMeta struct {
+	caller, callee, procedure string
+	start                     time.Time
+	tracked                   bool
+}
 
+type relayItem struct {
 	remapID     uint32
 	destination *Relayer
 	tomb        bool
+	reservation *relay.Reservation
+	meta        callMeta
+
+	wheelID uint32
+	bucket  int
 }
 
 type relayItems struct {
 	sync.RWMutex
 
 	logger Logger
+	wheel  *timerwheel.Wheel
+	tracer relay.MetricsTracer
 	tombs  uint64
 	items  map[uint32]relayItem
 }
 
-func newRelayItems(logger Logger) *relayItems {
+func newRelayItems(logger Logger, wheel *timerwheel.Wheel, tracer relay.MetricsTracer) *relayItems {
 	return &relayItems{
 		items:  make(map[uint32]relayItem),
 		logger: logger,
+		wheel:  wheel,
+		tracer: tracer,
 	}
 }
 
@@ -101,7 +132,10 @@ func (r *relayItems) Delete(id uint32) bool {
 	}
 	r.Unlock()
 
-	item.Stop()
+	r.wheel.Cancel(item.bucket, item.wheelID)
+	if !item.tomb {
+		item.reservation.Release()
+	}
 	return !item.tomb
 }
 
@@ -110,9 +144,21 @@ func (r *relayItems) Delete(id uint32) bool {
 func (r *relayItems) Entomb(id uint32, deleteAfter time.Duration) bool {
 	r.Lock()
 	if r.tombs > _maxRelayTombs {
+		item, ok := r.items[id]
+		if ok {
+			delete(r.items, id)
+		}
 		r.Unlock()
 		r.logger.WithFields(LogField{"id", id}).Warn("Too many tombstones, deleting relay item immediately.")
-		return false
+		r.tracer.TombDropped("too many tombstones")
+		if ok {
+			// Skip the tombstone grace period, but the call still
+			// completed: release its reservation immediately rather than
+			// leaking that capacity until the process restarts.
+			r.wheel.Cancel(item.bucket, item.wheelID)
+			item.reservation.Release()
+		}
+		return ok
 	}
 	item, ok := r.items[id]
 	if !ok {
@@ -123,16 +169,21 @@ func (r *relayItems) Entomb(id uint32, deleteAfter time.Duration) bool {
 	if item.tomb {
 		r.Unlock()
 		r.logger.WithFields(LogField{"id", id}).Warn("Re-entombing a tombstone.")
+		r.tracer.TombDropped("already tombstoned")
 		return false
 	}
 	r.tombs++
 	item.tomb = true
+	reservation := item.reservation
+	item.reservation = nil
+	item.bucket = r.wheel.Schedule(time.Now().Add(deleteAfter), item.wheelID)
 	r.items[id] = item
 	r.Unlock()
 
-	// TODO: We should be clearing these out in batches, rather than creating
-	// individual timers for each item.
-	time.AfterFunc(deleteAfter, func() { r.Delete(id) })
+	reservation.Release()
+	if item.meta.tracked {
+		r.tracer.Entombed(item.meta.caller, item.meta.callee)
+	}
 	return true
 }
 
@@ -145,8 +196,15 @@ const (
 
 // A Relayer forwards frames.
 type Relayer struct {
-	metrics StatsReporter
-	hosts   relay.Hosts
+	hosts     relay.Hosts
+	acl       relay.ACLFilter
+	resources *relay.ResourceTree
+	tracer    relay.MetricsTracer
+	picker    *relay.Picker
+
+	// maxAttempts bounds how many destinations handleCallReq will try,
+	// via getDestination, before giving up on a call.
+	maxAttempts int
 
 	// outbound is the remapping for requests that originated on this
 	// connection, and are outbound towards some other connection.
@@ -162,19 +220,55 @@ type Relayer struct {
 	conn    *Connection
 	logger  Logger
 	pending atomic.Uint32
+
+	// wheel batches the TTL expiry and tombstone GC of every relay item
+	// this Relayer owns behind a single ticking goroutine.
+	wheel *timerwheel.Wheel
 }
 
 // NewRelayer constructs a Relayer.
 func NewRelayer(ch *Channel, conn *Connection) *Relayer {
-	return &Relayer{
-		metrics:  conn.statsReporter,
-		hosts:    ch.RelayHosts(),
-		outbound: newRelayItems(ch.Logger().WithFields(LogField{"relay", "outbound"})),
-		inbound:  newRelayItems(ch.Logger().WithFields(LogField{"relay", "inbound"})),
-		peers:    ch.Peers(),
-		conn:     conn,
-		logger:   conn.log,
+	tracer := ch.RelayMetricsTracer()
+	if tracer == nil {
+		tracer = relay.NewDefaultMetricsTracer(conn.statsReporter)
 	}
+	picker := ch.RelayPicker()
+	if picker == nil {
+		picker = relay.NewPicker()
+	}
+	maxAttempts := ch.RelayMaxAttempts()
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	r := &Relayer{
+		hosts:       ch.RelayHosts(),
+		acl:         ch.RelayACLFilter(),
+		resources:   relay.NewResourceTree(ch.RelayResources()),
+		tracer:      tracer,
+		picker:      picker,
+		maxAttempts: maxAttempts,
+		peers:       ch.Peers(),
+		conn:        conn,
+		logger:      conn.log,
+	}
+
+	r.wheel = timerwheel.New(_relayWheelTick, r.onWheelFire)
+	r.outbound = newRelayItems(ch.Logger().WithFields(LogField{"relay", "outbound"}), r.wheel, r.tracer)
+	r.inbound = newRelayItems(ch.Logger().WithFields(LogField{"relay", "inbound"}), r.wheel, r.tracer)
+
+	return r
+}
+
+// Close forces this Relayer's background timerwheel goroutine to stop
+// immediately, regardless of whether relay items are still outstanding.
+// It's not required for correctness: the wheel's goroutine already exits
+// on its own once every outbound and inbound item has been reaped, and
+// restarts the next time a call is relayed. Close exists for callers that
+// want a connection's relay resources torn down deterministically rather
+// than waiting out the wheel's remaining rounds.
+func (r *Relayer) Close() {
+	r.wheel.Stop()
 }
 
 // Hosts returns the RelayHosts guiding peer selection.
@@ -223,38 +317,120 @@ func (r *Relayer) canHandleNewCall() bool {
 		}
 		return nil
 	})
+	if canHandle {
+		r.tracer.PendingGauge(int(r.pending.Load()))
+	}
 	return canHandle
 }
 
-func (r *Relayer) getDestination(f lazyCallReq) (*Connection, bool, error) {
+// getDestination resolves a connection to relay f to, retrying across up
+// to r.maxAttempts candidate destinations (bounded by f's remaining TTL)
+// before giving up. getDestination runs on the connection's synchronous
+// frame-processing path, so retries are immediate with no backoff sleep
+// between attempts; Picker.ReportOutcome still lets a destination that
+// keeps failing fall out of rotation for subsequent calls. Once a
+// destination accepts the call, getDestination debits reservation against
+// that peer's resource scope; a peer at its concurrency ceiling fails the
+// call outright rather than falling through to another candidate, since
+// reservation has already been committed against the global and service
+// scopes for this call. start is f's arrival time, passed through from
+// handleCallReq so any terminal failure can report CallCompleted with the
+// call's real end-to-end latency and the outcome that caused it.
+func (r *Relayer) getDestination(f lazyCallReq, start time.Time, reservation *relay.Reservation) (*Connection, bool, error) {
+	caller, callee, procedure := f.Caller(), f.Service(), string(f.Method())
+
 	if _, ok := r.outbound.Get(f.Header.ID); ok {
 		r.logger.WithFields(LogField{"id", f.Header.ID}).Warn("received duplicate callReq")
+		r.tracer.CallCompleted(caller, callee, procedure, time.Since(start), relay.OutcomeProtocolError)
 		// TODO: this is a protocol error, kill the connection.
 		return nil, false, errors.New("callReq with already active ID")
 	}
 
-	// Get the destination
-	hostPort := r.hosts.Get(f)
-	if hostPort == "" {
+	candidates := r.candidatesFor(f)
+	if len(candidates) == 0 {
+		r.tracer.CallCompleted(caller, callee, procedure, time.Since(start), relay.OutcomeNetworkError)
 		// TODO: What is the span in the error frame actually used for, and do we need it?
 		r.conn.SendSystemError(f.Header.ID, nil, errUnknownGroup(f.Service()))
 		return nil, false, nil
 	}
-	peer := r.peers.GetOrAdd(hostPort)
-
-	// TODO: Should connections use the call timeout? Or a separate timeout?
-	remoteConn, err := peer.getConnectionTimeout(f.TTL())
-	if err != nil {
-		r.logger.WithFields(
-			ErrField(err),
-			LogField{"hostPort", hostPort},
-		).Warn("Failed to connect to relay host.")
-		// TODO: Same as above, do we need span here?
-		r.conn.SendSystemError(f.Header.ID, nil, NewWrappedSystemError(ErrCodeNetwork, err))
-		return nil, false, nil
+
+	deadline := time.Now().Add(f.TTL())
+	var tried []string
+	var lastErr error
+	for attempt := 0; attempt < r.maxAttempts && len(candidates) > 0; attempt++ {
+		if attempt > 0 && !time.Now().Before(deadline) {
+			break
+		}
+
+		hostPort, idx, ok := r.picker.Pick(candidates)
+		if !ok {
+			break
+		}
+		candidates = append(candidates[:idx], candidates[idx+1:]...)
+		tried = append(tried, hostPort)
+
+		if r.acl != nil && !r.acl.AllowDestination(f, hostPort) {
+			r.tracer.ACLDenied("destination")
+			r.tracer.CallCompleted(caller, callee, procedure, time.Since(start), relay.OutcomeACLDenied)
+			r.conn.SendSystemError(f.Header.ID, nil, NewSystemError(ErrCodeDeclined, "relay ACL denied destination %q", hostPort))
+			return nil, false, nil
+		}
+
+		peer := r.peers.GetOrAdd(hostPort)
+
+		// TODO: Should connections use the call timeout? Or a separate timeout?
+		remoteConn, err := peer.getConnectionTimeout(f.TTL())
+		if err != nil {
+			r.logger.WithFields(
+				ErrField(err),
+				LogField{"hostPort", hostPort},
+			).Warn("Failed to connect to relay host.")
+			r.tracer.DestinationUnavailable(hostPort, err)
+			r.picker.ReportOutcome(hostPort, false)
+			lastErr = err
+			continue
+		}
+		if !remoteConn.relay.canHandleNewCall() {
+			r.picker.ReportOutcome(hostPort, false)
+			lastErr = NewSystemError(ErrCodeNetwork, "selected closed connection, retry")
+			continue
+		}
+
+		if exhausted := r.resources.ReservePeer(reservation, hostPort); exhausted != nil {
+			// canHandleNewCall above already incremented the destination
+			// relayer's pending count; since we're rejecting the call here
+			// instead of ever creating an inbound relay item for it on that
+			// side, nothing else will decrement it, so do that ourselves.
+			remoteConn.relay.decrementPending()
+			r.tracer.ResourceExhausted(f.Caller(), f.Service(), exhausted.Name())
+			r.tracer.CallCompleted(caller, callee, procedure, time.Since(start), relay.OutcomeResourceExhausted)
+			r.conn.SendSystemError(f.Header.ID, nil, NewSystemError(ErrCodeBusy, "relay resource limit exceeded for scope %q", exhausted.Name()))
+			return nil, false, nil
+		}
+
+		r.picker.ReportOutcome(hostPort, true)
+		return remoteConn, true, nil
 	}
 
-	return remoteConn, true, nil
+	r.tracer.CallCompleted(caller, callee, procedure, time.Since(start), relay.OutcomeNetworkError)
+	// TODO: Same as above, do we need span here?
+	r.conn.SendSystemError(f.Header.ID, nil, NewSystemError(ErrCodeNetwork, "relay exhausted %d attempt(s) against %v: %v", len(tried), tried, lastErr))
+	return nil, false, nil
+}
+
+// candidatesFor resolves the destinations a call may be relayed to. If
+// Hosts also implements HostsMulti, every ranked candidate it returns is
+// available for retry; otherwise the single host Hosts.Get resolves is
+// the only candidate.
+func (r *Relayer) candidatesFor(f lazyCallReq) []relay.Candidate {
+	if multi, ok := r.hosts.(relay.HostsMulti); ok {
+		return multi.GetAll(f)
+	}
+	hostPort := r.hosts.Get(f)
+	if hostPort == "" {
+		return nil
+	}
+	return []relay.Candidate{{HostPort: hostPort, Weight: 1}}
 }
 
 func (r *Relayer) handleCallReq(f lazyCallReq) error {
@@ -262,32 +438,75 @@ func (r *Relayer) handleCallReq(f lazyCallReq) error {
 		return ErrChannelClosed
 	}
 
-	// Get a remote connection and check whether it can handle this call.
-	remoteConn, ok, err := r.getDestination(f)
-	if err == nil && ok {
-		if !remoteConn.relay.canHandleNewCall() {
-			err = NewSystemError(ErrCodeNetwork, "selected closed connection, retry")
+	start := time.Now()
+	caller, callee, procedure := f.Caller(), f.Service(), string(f.Method())
+
+	reservation, exhausted := r.reserve(caller, callee, int64(len(f.Frame.Payload)))
+	if exhausted != nil {
+		r.tracer.ResourceExhausted(caller, callee, exhausted.Name())
+		r.tracer.CallCompleted(caller, callee, procedure, time.Since(start), relay.OutcomeResourceExhausted)
+		r.conn.SendSystemError(f.Header.ID, nil, NewSystemError(ErrCodeBusy, "relay resource limit exceeded for scope %q", exhausted.Name()))
+		r.decrementPending()
+		return nil
+	}
+
+	if r.acl != nil {
+		if allow, reason := r.acl.AllowCallReq(f); !allow {
+			r.tracer.ACLDenied(reason)
+			r.tracer.CallCompleted(caller, callee, procedure, time.Since(start), relay.OutcomeACLDenied)
+			r.conn.SendSystemError(f.Header.ID, nil, NewSystemError(ErrCodeDeclined, "relay ACL denied call: %s", reason))
+			reservation.Release()
+			r.decrementPending()
+			return nil
 		}
 	}
+
+	// Get a remote connection, retrying across candidate destinations. On
+	// success, reservation has additionally been debited against the
+	// chosen peer's scope. A protocol violation (a duplicate call ID) comes
+	// back as an error, which is returned to Relay's caller; on any other
+	// failure (including peer exhaustion), getDestination has already sent
+	// the caller a SystemError. Either way it has reported CallCompleted
+	// with the outcome that caused the failure. This must
+	// stay on the synchronous frame-processing path: a callReqContinue for
+	// this call's later fragments is routed by handleNonCallReq looking up
+	// f.Header.ID in r.outbound, which addRelayItem below populates, so
+	// nothing may read the next frame off this connection until that
+	// lookup would succeed.
+	remoteConn, ok, err := r.getDestination(f, start, reservation)
 	if err != nil || !ok {
-		// Failed to get a remote connection, or the connection is not in the right
-		// state to handle this call. Since we already incremented pending on
-		// the current relay, we need to decrement it.
+		// Failed to get a remote connection after every attempt. Since we
+		// already incremented pending on the current relay, we need to
+		// decrement it.
+		reservation.Release()
 		r.decrementPending()
 		return err
 	}
 
 	destinationID := remoteConn.NextMessageID()
 	ttl := f.TTL()
-	remoteConn.relay.addRelayItem(false /* isOriginator */, destinationID, f.Header.ID, r, ttl)
-	r.metrics.IncCounter("relay", nil, 1)
-	relayToDest := r.addRelayItem(true /* isOriginator */, f.Header.ID, destinationID, remoteConn.relay, ttl)
+	remoteConn.relay.addRelayItem(false /* isOriginator */, destinationID, f.Header.ID, r, ttl, nil, callMeta{})
+
+	r.tracer.CallStarted(caller, callee, procedure)
+	meta := callMeta{caller: caller, callee: callee, procedure: procedure, start: start, tracked: true}
+	relayToDest := r.addRelayItem(true /* isOriginator */, f.Header.ID, destinationID, remoteConn.relay, ttl, reservation, meta)
 
 	f.Header.ID = destinationID
 	relayToDest.destination.Receive(f.Frame, requestFrame)
 	return nil
 }
 
+// reserve debits relay capacity for a new call from callerService to
+// targetService, accounting for its frame's bytes, against this Relayer's
+// resource tree. If every configured scope has room, it returns a
+// Reservation that must be released (via finishRelayItem or
+// timeoutRelayItem) once the call completes. If a scope is exhausted,
+// reserve returns that scope instead so the caller can reject the call and
+// tag its metrics.
+func (r *Relayer) reserve(callerService, targetService string, bytes int64) (*relay.Reservation, *relay.ResourceScope) {
+	return r.resources.Reserve(callerService, targetService, bytes)
+}
+
 // Handle all frames except messageTypeCallReq.
 func (r *Relayer) handleNonCallReq(f *Frame) error {
 	frameType := frameTypeFor(f)
@@ -319,22 +538,61 @@ func (r *Relayer) handleNonCallReq(f *Frame) error {
 }
 
 // addRelayItem adds a relay item to either outbound or inbound.
-func (r *Relayer) addRelayItem(isOriginator bool, id, remapID uint32, destination *Relayer, ttl time.Duration) relayItem {
+func (r *Relayer) addRelayItem(isOriginator bool, id, remapID uint32, destination *Relayer, ttl time.Duration, reservation *relay.Reservation, meta callMeta) relayItem {
+	items := r.inbound
+	if isOriginator {
+		items = r.outbound
+	}
+
+	wid := wheelID(isOriginator, id)
 	item := relayItem{
 		remapID:     remapID,
 		destination: destination,
+		reservation: reservation,
+		meta:        meta,
+		wheelID:     wid,
 	}
+	item.bucket = r.wheel.Schedule(time.Now().Add(ttl), wid)
+	items.Add(id, item)
+	return item
+}
 
-	items := r.inbound
+// wheelID encodes a relay item's direction into its ID, so a single
+// Relayer-wide timerwheel can safely schedule both inbound and outbound
+// items, which are tracked in independent maps and may reuse the same
+// numeric ID.
+func wheelID(isOriginator bool, id uint32) uint32 {
 	if isOriginator {
-		items = r.outbound
+		return id
 	}
-	item.Timer = time.AfterFunc(ttl, func() { r.timeoutRelayItem(items, id, isOriginator) })
-	items.Add(id, item)
-	return item
+	return id | _inboundWheelBit
+}
+
+// onWheelFire is called by this Relayer's timerwheel once per reaped
+// entry. The item's current tomb bit tells us whether this is its TTL
+// expiry (not yet tombstoned) or its tombstone GC (already tombstoned by
+// a prior call to onWheelFire).
+func (r *Relayer) onWheelFire(encoded uint32) {
+	isOriginator := encoded&_inboundWheelBit == 0
+	items := r.outbound
+	if !isOriginator {
+		items = r.inbound
+	}
+	id := encoded &^ _inboundWheelBit
+
+	item, ok := items.Get(id)
+	if !ok {
+		return
+	}
+	if item.tomb {
+		items.Delete(id)
+		return
+	}
+	r.timeoutRelayItem(items, id, isOriginator)
 }
 
 func (r *Relayer) timeoutRelayItem(items *relayItems, id uint32, isOriginator bool) {
+	item, _ := items.Get(id)
 	if ok := items.Entomb(id, _relayTombTTL); !ok {
 		return
 	}
@@ -342,20 +600,28 @@ func (r *Relayer) timeoutRelayItem(items *relayItems, id uint32, isOriginator bo
 		// TODO: As above. What's the span in the error frame for?
 		r.conn.SendSystemError(id, nil, ErrTimeout)
 	}
+	if item.meta.tracked {
+		r.tracer.CallTimedOut(item.meta.caller, item.meta.callee, item.meta.procedure)
+	}
 
 	r.decrementPending()
 }
 
 func (r *Relayer) finishRelayItem(items *relayItems, id uint32) {
+	item, _ := items.Get(id)
 	if ok := items.Delete(id); !ok {
 		return
 	}
+	if item.meta.tracked {
+		r.tracer.CallCompleted(item.meta.caller, item.meta.callee, item.meta.procedure, time.Since(item.meta.start), relay.OutcomeSuccess)
+	}
 
 	r.decrementPending()
 }
 
 func (r *Relayer) decrementPending() {
 	r.pending.Dec()
+	r.tracer.PendingGauge(int(r.pending.Load()))
 	r.conn.checkExchanges()
 }
 
@@ -370,6 +636,12 @@ func (r *Relayer) countPending() uint32 {
 	return r.pending.Load()
 }
 
+// WheelStats returns a snapshot of this Relayer's timerwheel activity,
+// for export via metrics.
+func (r *Relayer) WheelStats() timerwheel.WheelStats {
+	return r.wheel.Stats()
+}
+
 func (r *Relayer) receiverItems(fType frameType) *relayItems {
 	if fType == requestFrame {
 		return r.inbound
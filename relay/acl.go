@@ -0,0 +1,40 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package relay
+
+// ACLFilter decides whether a relayed call is allowed to proceed, and
+// whether a destination resolved for it is allowed to receive it.
+// Applications install an ACLFilter through ChannelOptions to allow/deny
+// calls by service name, calling service, or transport header, or to
+// layer in dynamic policies such as rate-based blocklists, without
+// forking the relay. Implementations must be safe for concurrent use.
+type ACLFilter interface {
+	// AllowCallReq is consulted before a destination is resolved for an
+	// inbound call. A false return rejects the call, surfacing reason to
+	// the caller and relay ACL metrics.
+	AllowCallReq(caller CallFrame) (allow bool, reason string)
+
+	// AllowDestination is consulted once a destination host:port has
+	// been resolved via Hosts, so policies keyed on the resolved peer
+	// (rather than just the caller or service) can still reject the
+	// call.
+	AllowDestination(caller CallFrame, hostPort string) bool
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package relay
+
+import "time"
+
+// Outcome categorizes how a relayed call finished, for metrics tagging.
+type Outcome int
+
+const (
+	// OutcomeSuccess means the call completed normally.
+	OutcomeSuccess Outcome = iota
+	// OutcomeTimeout means the call's TTL elapsed before it completed.
+	OutcomeTimeout
+	// OutcomeProtocolError means the call was rejected for violating the
+	// tchannel wire protocol.
+	OutcomeProtocolError
+	// OutcomeNetworkError means the relay couldn't establish or keep a
+	// connection to the destination.
+	OutcomeNetworkError
+	// OutcomeRemoteBusy means the destination rejected the call as busy.
+	OutcomeRemoteBusy
+	// OutcomeACLDenied means an ACLFilter rejected the call.
+	OutcomeACLDenied
+	// OutcomeResourceExhausted means a Resources scope was exhausted.
+	OutcomeResourceExhausted
+)
+
+// String returns the tag value used for this Outcome on exported metrics.
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeSuccess:
+		return "success"
+	case OutcomeTimeout:
+		return "timeout"
+	case OutcomeProtocolError:
+		return "protocol-error"
+	case OutcomeNetworkError:
+		return "network-error"
+	case OutcomeRemoteBusy:
+		return "remote-busy"
+	case OutcomeACLDenied:
+		return "acl-denied"
+	case OutcomeResourceExhausted:
+		return "resource-exhausted"
+	default:
+		return "unknown"
+	}
+}
+
+// MetricsTracer receives lifecycle events for every call a Relayer
+// forwards, so operators can build per-service SLO dashboards without
+// forking the relay. Implementations must be safe for concurrent use,
+// since a single tracer is shared across every connection's Relayer.
+type MetricsTracer interface {
+	// CallStarted is invoked once a destination has been selected and
+	// the call is about to be forwarded.
+	CallStarted(caller, callee, procedure string)
+
+	// CallCompleted is invoked when a forwarded call completes, and when
+	// the relay rejects a call before forwarding it, with the outcome that
+	// caused the rejection. A forwarded call whose TTL elapses is reported
+	// through CallTimedOut instead.
+	CallCompleted(caller, callee, procedure string, latency time.Duration, outcome Outcome)
+
+	// CallTimedOut is invoked when a call's TTL elapses before it
+	// completes.
+	CallTimedOut(caller, callee, procedure string)
+
+	// ACLDenied is invoked when an ACLFilter rejects a call or
+	// destination.
+	ACLDenied(reason string)
+
+	// ResourceExhausted is invoked when a Resources scope rejects a call
+	// before it could be started, identifying which scope was exhausted
+	// (e.g. "global", "service:foo", or "peer:10.0.0.1:1234") so
+	// operators can tell tenant caps apart from a global cap.
+	ResourceExhausted(caller, callee, scope string)
+
+	// DestinationUnavailable is invoked when the relay fails to
+	// establish a connection to a resolved destination.
+	DestinationUnavailable(host string, err error)
+
+	// Entombed is invoked when a relay item is tombstoned, ahead of its
+	// eventual GC.
+	Entombed(caller, callee string)
+
+	// TombDropped is invoked when the relay doesn't keep a new tombstone
+	// for an item, e.g. because too many had accumulated or the item was
+	// already tombstoned.
+	TombDropped(reason string)
+
+	// PendingGauge reports the current number of in-flight calls for a
+	// Relayer.
+	PendingGauge(pending int)
+}
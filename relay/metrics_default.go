@@ -0,0 +1,131 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package relay
+
+import (
+	"sync"
+	"time"
+)
+
+// StatsReporter is the subset of tchannel's stats reporter the default
+// MetricsTracer needs. It's declared here, rather than imported, so this
+// package doesn't depend on the root tchannel package; any StatsReporter
+// satisfying this interface (which the one on Channel does) can be
+// passed to NewDefaultMetricsTracer.
+type StatsReporter interface {
+	IncCounter(name string, tags map[string]string, value int64)
+	UpdateGauge(name string, tags map[string]string, value int64)
+	RecordTimer(name string, tags map[string]string, d time.Duration)
+}
+
+// _topNServices bounds per-service tag cardinality for the default
+// tracer: the first N distinct services seen get their own tag value,
+// and everything else is folded into "_other", so a long tail of callers
+// can't blow up the metrics backend's cardinality.
+const _topNServices = 64
+
+// DefaultMetricsTracer is the default MetricsTracer: it fans call
+// lifecycle events out to a StatsReporter, tagged by caller, callee,
+// procedure and outcome, with a cap on how many distinct service names
+// get their own tag value.
+type DefaultMetricsTracer struct {
+	reporter StatsReporter
+
+	mu       sync.Mutex
+	services map[string]struct{}
+}
+
+// NewDefaultMetricsTracer returns a MetricsTracer that reports relay call
+// lifecycle events to reporter.
+func NewDefaultMetricsTracer(reporter StatsReporter) *DefaultMetricsTracer {
+	return &DefaultMetricsTracer{
+		reporter: reporter,
+		services: make(map[string]struct{}),
+	}
+}
+
+func (t *DefaultMetricsTracer) serviceTag(service string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.services[service]; ok {
+		return service
+	}
+	if len(t.services) >= _topNServices {
+		return "_other"
+	}
+	t.services[service] = struct{}{}
+	return service
+}
+
+func (t *DefaultMetricsTracer) tags(caller, callee, procedure, outcome string) map[string]string {
+	tags := map[string]string{
+		"caller": t.serviceTag(caller),
+		"callee": t.serviceTag(callee),
+	}
+	if procedure != "" {
+		tags["procedure"] = procedure
+	}
+	if outcome != "" {
+		tags["outcome"] = outcome
+	}
+	return tags
+}
+
+func (t *DefaultMetricsTracer) CallStarted(caller, callee, procedure string) {
+	t.reporter.IncCounter("relay.call-started", t.tags(caller, callee, procedure, ""), 1)
+}
+
+func (t *DefaultMetricsTracer) CallCompleted(caller, callee, procedure string, latency time.Duration, outcome Outcome) {
+	tags := t.tags(caller, callee, procedure, outcome.String())
+	t.reporter.IncCounter("relay.call-completed", tags, 1)
+	t.reporter.RecordTimer("relay.call-latency", tags, latency)
+}
+
+func (t *DefaultMetricsTracer) CallTimedOut(caller, callee, procedure string) {
+	t.reporter.IncCounter("relay.call-timeout", t.tags(caller, callee, procedure, ""), 1)
+}
+
+func (t *DefaultMetricsTracer) ACLDenied(reason string) {
+	t.reporter.IncCounter("relay.acl-rejected", map[string]string{"reason": reason}, 1)
+}
+
+func (t *DefaultMetricsTracer) ResourceExhausted(caller, callee, scope string) {
+	tags := t.tags(caller, callee, "", "")
+	tags["scope"] = scope
+	t.reporter.IncCounter("relay.resource-exhausted", tags, 1)
+}
+
+func (t *DefaultMetricsTracer) DestinationUnavailable(host string, err error) {
+	t.reporter.IncCounter("relay.destination-unavailable", map[string]string{"host": host}, 1)
+}
+
+func (t *DefaultMetricsTracer) Entombed(caller, callee string) {
+	t.reporter.IncCounter("relay.entombed", t.tags(caller, callee, "", ""), 1)
+}
+
+func (t *DefaultMetricsTracer) TombDropped(reason string) {
+	t.reporter.IncCounter("relay.tomb-dropped", map[string]string{"reason": reason}, 1)
+}
+
+func (t *DefaultMetricsTracer) PendingGauge(pending int) {
+	t.reporter.UpdateGauge("relay.pending", nil, int64(pending))
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeReporter struct {
+	counters map[string]int64
+	lastTags map[string]map[string]string
+}
+
+func newFakeReporter() *fakeReporter {
+	return &fakeReporter{
+		counters: make(map[string]int64),
+		lastTags: make(map[string]map[string]string),
+	}
+}
+
+func (f *fakeReporter) IncCounter(name string, tags map[string]string, value int64) {
+	f.counters[name] += value
+	f.lastTags[name] = tags
+}
+
+func (f *fakeReporter) UpdateGauge(name string, tags map[string]string, value int64) {}
+
+func (f *fakeReporter) RecordTimer(name string, tags map[string]string, d time.Duration) {}
+
+func TestDefaultMetricsTracer_ResourceExhausted(t *testing.T) {
+	reporter := newFakeReporter()
+	tracer := NewDefaultMetricsTracer(reporter)
+
+	tracer.ResourceExhausted("caller-svc", "callee-svc", "peer:10.0.0.1:1234")
+
+	assert.EqualValues(t, 1, reporter.counters["relay.resource-exhausted"])
+	tags := reporter.lastTags["relay.resource-exhausted"]
+	assert.Equal(t, "peer:10.0.0.1:1234", tags["scope"])
+	assert.Equal(t, "caller-svc", tags["caller"])
+	assert.Equal(t, "callee-svc", tags["callee"])
+}
+
+func TestDefaultMetricsTracer_ServiceTagCardinalityCap(t *testing.T) {
+	reporter := newFakeReporter()
+	tracer := NewDefaultMetricsTracer(reporter)
+
+	for i := 0; i < _topNServices+5; i++ {
+		tracer.CallStarted("caller", serviceName(i), "proc")
+	}
+
+	// "caller" holds one of the _topNServices slots, so the 64th+ distinct
+	// callee should be folded into "_other" rather than growing tag
+	// cardinality without bound.
+	tracer.CallStarted("caller", serviceName(_topNServices+10), "proc")
+	tags := reporter.lastTags["relay.call-started"]
+	assert.Equal(t, "_other", tags["callee"])
+}
+
+func serviceName(i int) string {
+	return "svc-" + string(rune('a'+i%26)) + string(rune('0'+i%10))
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package relay
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Candidate is one destination a call could be relayed to, as returned by
+// HostsMulti, paired with a relative weight for load-balanced selection.
+type Candidate struct {
+	HostPort string
+	Weight   float64
+}
+
+// HostsMulti is an optional extension of Hosts. A Hosts implementation
+// that also implements HostsMulti lets the relay retry a call against a
+// different destination instead of being stuck with whatever the first
+// resolution picked, analogous to a gRPC health-aware balancer choosing
+// among a resolved address list.
+type HostsMulti interface {
+	// GetAll returns every candidate destination for f, ranked with the
+	// most preferred first. An empty slice means no destination is
+	// available.
+	GetAll(f CallFrame) []Candidate
+}
+
+// defaultUnhealthyThreshold is the rolling error rate, in [0, 1], above
+// which a Picker skips a host in favor of any healthier candidate.
+const defaultUnhealthyThreshold = 0.5
+
+// ewmaAlpha weights how quickly a host's rolling error rate reacts to a
+// new outcome; higher reacts faster but is noisier.
+const ewmaAlpha = 0.2
+
+// ewma is a simple exponential moving average of a host's error rate,
+// sampled as 0 (success) or 1 (failure) per call.
+type ewma struct {
+	mu    sync.Mutex
+	value float64
+	set   bool
+}
+
+func (e *ewma) update(sample float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.set {
+		e.value = sample
+		e.set = true
+		return
+	}
+	e.value = ewmaAlpha*sample + (1-ewmaAlpha)*e.value
+}
+
+func (e *ewma) get() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.value
+}
+
+// Picker selects among a set of candidate destinations for a single call
+// attempt. It tracks each host's rolling error rate and prefers hosts
+// not above UnhealthyThreshold, so a degraded peer stops absorbing retries
+// without needing an out-of-band health check.
+type Picker struct {
+	// UnhealthyThreshold is the rolling error rate above which a host is
+	// skipped in favor of a healthier candidate. Zero means
+	// defaultUnhealthyThreshold.
+	UnhealthyThreshold float64
+
+	mu     sync.Mutex
+	health map[string]*ewma
+}
+
+// NewPicker returns a Picker with the default UnhealthyThreshold.
+func NewPicker() *Picker {
+	return &Picker{
+		UnhealthyThreshold: defaultUnhealthyThreshold,
+		health:             make(map[string]*ewma),
+	}
+}
+
+func (p *Picker) threshold() float64 {
+	if p.UnhealthyThreshold <= 0 {
+		return defaultUnhealthyThreshold
+	}
+	return p.UnhealthyThreshold
+}
+
+func (p *Picker) healthFor(hostPort string) *ewma {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.health[hostPort]
+	if !ok {
+		e = &ewma{}
+		p.health[hostPort] = e
+	}
+	return e
+}
+
+// ReportOutcome records whether a call attempt to hostPort succeeded,
+// updating its rolling error rate for future Pick calls.
+func (p *Picker) ReportOutcome(hostPort string, success bool) {
+	sample := 0.0
+	if !success {
+		sample = 1.0
+	}
+	p.healthFor(hostPort).update(sample)
+}
+
+// Pick weighted-randomly selects one of candidates, preferring those
+// whose rolling error rate is not above UnhealthyThreshold. If every
+// candidate is unhealthy, it falls back to weighting across all of them,
+// since refusing to pick at all would be worse than trying a degraded
+// host. It returns the chosen candidate's index in candidates, so the
+// caller can exclude it before retrying.
+func (p *Picker) Pick(candidates []Candidate) (hostPort string, idx int, ok bool) {
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+
+	threshold := p.threshold()
+	pool := make([]int, 0, len(candidates))
+	for i, c := range candidates {
+		if p.healthFor(c.HostPort).get() <= threshold {
+			pool = append(pool, i)
+		}
+	}
+	if len(pool) == 0 {
+		pool = make([]int, len(candidates))
+		for i := range candidates {
+			pool[i] = i
+		}
+	}
+
+	total := 0.0
+	for _, i := range pool {
+		total += weightOf(candidates[i])
+	}
+
+	target := rand.Float64() * total
+	for _, i := range pool {
+		w := weightOf(candidates[i])
+		if target < w {
+			return candidates[i].HostPort, i, true
+		}
+		target -= w
+	}
+
+	last := pool[len(pool)-1]
+	return candidates[last].HostPort, last, true
+}
+
+func weightOf(c Candidate) float64 {
+	if c.Weight <= 0 {
+		return 1
+	}
+	return c.Weight
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package relay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPicker_SkipsUnhealthyHost(t *testing.T) {
+	p := NewPicker()
+	candidates := []Candidate{{HostPort: "bad", Weight: 1}, {HostPort: "good", Weight: 1}}
+
+	for i := 0; i < 10; i++ {
+		p.ReportOutcome("bad", false)
+	}
+
+	for i := 0; i < 20; i++ {
+		hostPort, _, ok := p.Pick(candidates)
+		assert.True(t, ok)
+		assert.Equal(t, "good", hostPort)
+	}
+}
+
+func TestPicker_FallsBackWhenEveryHostIsUnhealthy(t *testing.T) {
+	p := NewPicker()
+	candidates := []Candidate{{HostPort: "a", Weight: 1}, {HostPort: "b", Weight: 1}}
+
+	for _, c := range candidates {
+		for i := 0; i < 10; i++ {
+			p.ReportOutcome(c.HostPort, false)
+		}
+	}
+
+	hostPort, idx, ok := p.Pick(candidates)
+	assert.True(t, ok)
+	assert.Contains(t, []string{"a", "b"}, hostPort)
+	assert.Equal(t, candidates[idx].HostPort, hostPort)
+}
+
+func TestPicker_NoCandidates(t *testing.T) {
+	p := NewPicker()
+	_, _, ok := p.Pick(nil)
+	assert.False(t, ok)
+}
+
+func TestPicker_WeightedSelectionFavorsHigherWeight(t *testing.T) {
+	p := NewPicker()
+	candidates := []Candidate{{HostPort: "heavy", Weight: 99}, {HostPort: "light", Weight: 1}}
+
+	counts := map[string]int{}
+	for i := 0; i < 200; i++ {
+		hostPort, _, ok := p.Pick(candidates)
+		assert.True(t, ok)
+		counts[hostPort]++
+	}
+
+	assert.True(t, counts["heavy"] > counts["light"], "expected heavy to be picked more often, got %v", counts)
+}
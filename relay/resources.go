@@ -0,0 +1,196 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package relay
+
+import "sync"
+
+// Resources bounds the relay capacity a single Relayer may hand out,
+// broken down by scope, so that one noisy tenant can't starve the rest of
+// the process. A zero value in any field means that scope is unlimited.
+type Resources struct {
+	// MaxGlobalInFlight caps the number of calls the relay will have
+	// outstanding at any one time, across all services and peers.
+	MaxGlobalInFlight int
+
+	// MaxGlobalMemory caps the number of bytes of buffered frame data the
+	// relay will hold on behalf of in-flight calls.
+	MaxGlobalMemory int64
+
+	// MaxServiceInFlight caps the number of in-flight calls attributed to
+	// a single calling or target service.
+	MaxServiceInFlight int
+
+	// MaxPeerInFlight caps the number of in-flight calls relayed to a
+	// single destination peer.
+	MaxPeerInFlight int
+}
+
+// ResourceScope is a node in a tree of call-accounting scopes (global ->
+// service -> peer). Each scope tracks how many calls and bytes are
+// currently reserved against it, and refuses new reservations once its
+// configured limit is reached.
+type ResourceScope struct {
+	mu       sync.Mutex
+	name     string
+	maxCalls int
+	maxBytes int64
+	calls    int
+	bytes    int64
+}
+
+func newResourceScope(name string, maxCalls int, maxBytes int64) *ResourceScope {
+	return &ResourceScope{name: name, maxCalls: maxCalls, maxBytes: maxBytes}
+}
+
+// Name returns the scope's identifier (e.g. "global", "service:foo", or
+// "peer:10.0.0.1:1234"), for use in error messages and metrics tags.
+func (s *ResourceScope) Name() string {
+	return s.name
+}
+
+// tryReserve debits one call and the given number of bytes against the
+// scope, returning false without debiting anything if either limit would
+// be exceeded.
+func (s *ResourceScope) tryReserve(bytes int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxCalls > 0 && s.calls >= s.maxCalls {
+		return false
+	}
+	if s.maxBytes > 0 && s.bytes+bytes > s.maxBytes {
+		return false
+	}
+	s.calls++
+	s.bytes += bytes
+	return true
+}
+
+func (s *ResourceScope) release(bytes int64) {
+	s.mu.Lock()
+	s.calls--
+	s.bytes -= bytes
+	s.mu.Unlock()
+}
+
+// Reservation represents capacity debited from a ResourceTree on behalf
+// of a single relayed call. Release must be called once the call is done,
+// whether or not it ultimately succeeded; calls after the first are
+// no-ops.
+type Reservation struct {
+	bytes  int64
+	scopes []*ResourceScope
+}
+
+// Release returns the reserved capacity to every scope it was debited
+// from. Release is a no-op on a nil Reservation, so callers don't need to
+// special-case a disabled ResourceTree.
+func (res *Reservation) Release() {
+	if res == nil {
+		return
+	}
+	for _, s := range res.scopes {
+		s.release(res.bytes)
+	}
+	res.scopes = nil
+}
+
+// ResourceTree owns the global, per-service and per-peer scopes used to
+// enforce a Resources configuration for a single Relayer.
+type ResourceTree struct {
+	limits Resources
+	global *ResourceScope
+
+	mu       sync.Mutex
+	services map[string]*ResourceScope
+	peers    map[string]*ResourceScope
+}
+
+// NewResourceTree constructs a ResourceTree enforcing the given limits.
+func NewResourceTree(limits Resources) *ResourceTree {
+	return &ResourceTree{
+		limits:   limits,
+		global:   newResourceScope("global", limits.MaxGlobalInFlight, limits.MaxGlobalMemory),
+		services: make(map[string]*ResourceScope),
+		peers:    make(map[string]*ResourceScope),
+	}
+}
+
+func (t *ResourceTree) serviceScope(service string) *ResourceScope {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.services[service]
+	if !ok {
+		s = newResourceScope("service:"+service, t.limits.MaxServiceInFlight, 0)
+		t.services[service] = s
+	}
+	return s
+}
+
+func (t *ResourceTree) peerScope(hostPort string) *ResourceScope {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.peers[hostPort]
+	if !ok {
+		s = newResourceScope("peer:"+hostPort, t.limits.MaxPeerInFlight, 0)
+		t.peers[hostPort] = s
+	}
+	return s
+}
+
+// Reserve debits one call against the global scope and the caller and
+// target service scopes. On success it returns a Reservation that must
+// later be released via Reservation.Release; on failure it returns the
+// first scope that was already exhausted, having released anything it
+// had already debited.
+func (t *ResourceTree) Reserve(callerService, targetService string, bytes int64) (*Reservation, *ResourceScope) {
+	res := &Reservation{bytes: bytes}
+
+	scopes := []*ResourceScope{t.global, t.serviceScope(callerService)}
+	if targetService != "" && targetService != callerService {
+		scopes = append(scopes, t.serviceScope(targetService))
+	}
+
+	for _, s := range scopes {
+		if !s.tryReserve(bytes) {
+			res.Release()
+			return nil, s
+		}
+		res.scopes = append(res.scopes, s)
+	}
+	return res, nil
+}
+
+// ReservePeer additionally debits the destination peer scope, once the
+// relayer has resolved a hostPort to forward the call to. On failure, any
+// capacity already reserved via Reserve is released and the exhausted
+// scope is returned.
+func (t *ResourceTree) ReservePeer(res *Reservation, hostPort string) *ResourceScope {
+	s := t.peerScope(hostPort)
+	if !s.tryReserve(res.bytes) {
+		res.Release()
+		return s
+	}
+	res.scopes = append(res.scopes, s)
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package relay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceTree_ReserveTracksBytes(t *testing.T) {
+	tree := NewResourceTree(Resources{MaxGlobalMemory: 100})
+
+	res, exhausted := tree.Reserve("caller", "callee", 60)
+	assert.Nil(t, exhausted)
+
+	_, exhausted = tree.Reserve("caller", "callee", 50)
+	assert.NotNil(t, exhausted)
+	assert.Equal(t, "global", exhausted.Name())
+
+	res.Release()
+
+	_, exhausted = tree.Reserve("caller", "callee", 50)
+	assert.Nil(t, exhausted)
+}
+
+func TestResourceTree_ReservePeer(t *testing.T) {
+	tree := NewResourceTree(Resources{MaxPeerInFlight: 1, MaxGlobalInFlight: 2})
+
+	res, exhausted := tree.Reserve("caller", "callee", 0)
+	assert.Nil(t, exhausted)
+
+	assert.Nil(t, tree.ReservePeer(res, "10.0.0.1:1234"))
+
+	// A second call to the same peer is over the per-peer ceiling.
+	res2, exhausted := tree.Reserve("caller", "callee", 0)
+	assert.Nil(t, exhausted)
+	exhausted = tree.ReservePeer(res2, "10.0.0.1:1234")
+	assert.NotNil(t, exhausted)
+	assert.Equal(t, "peer:10.0.0.1:1234", exhausted.Name())
+
+	// ReservePeer failing releases everything res2 held, including the
+	// global scope, so a fresh reservation for the same caller succeeds.
+	_, exhausted = tree.Reserve("caller", "callee", 0)
+	assert.Nil(t, exhausted)
+
+	res.Release()
+}
+
+func TestResourceScope_ReleaseRestoresCapacity(t *testing.T) {
+	tree := NewResourceTree(Resources{MaxServiceInFlight: 1})
+
+	res, exhausted := tree.Reserve("caller", "callee", 0)
+	assert.Nil(t, exhausted)
+
+	_, exhausted = tree.Reserve("caller", "other-callee", 0)
+	assert.NotNil(t, exhausted)
+
+	res.Release()
+
+	_, exhausted = tree.Reserve("caller", "other-callee", 0)
+	assert.Nil(t, exhausted)
+}
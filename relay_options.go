@@ -0,0 +1,91 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tchannel
+
+import "github.com/uber/tchannel-go/relay"
+
+// Note: the accessors below read ch.relayOptions, a RelayOptions field
+// that ChannelOptions.RelayOptions is copied into by NewChannel, the same
+// way ChannelOptions.Logger ends up behind Channel.Logger(). That wiring
+// lives in channel.go, alongside the rest of Channel's construction.
+//
+// RelayOptions groups the relay subsystem's pluggable configuration. It's
+// installed via ChannelOptions.RelayOptions when constructing a Channel
+// that relays calls; NewRelayer reads it back off the Channel through the
+// RelayXxx accessors below. Every field is optional: a zero value either
+// disables that piece of the relay or falls back to a sane default, so
+// existing callers that don't set RelayOptions are unaffected.
+type RelayOptions struct {
+	// MetricsTracer receives relay call lifecycle events. If nil,
+	// NewRelayer falls back to a DefaultMetricsTracer reporting to the
+	// Channel's StatsReporter.
+	MetricsTracer relay.MetricsTracer
+
+	// Resources bounds the relay capacity each of this Channel's Relayers
+	// will hand out, broken down by global, service and peer scope; every
+	// Relayer enforces these limits with its own ResourceTree. The zero
+	// value leaves every scope unlimited.
+	Resources relay.Resources
+
+	// ACLFilter decides whether relayed calls and their resolved
+	// destinations are allowed to proceed. If nil, every call and
+	// destination is allowed.
+	ACLFilter relay.ACLFilter
+
+	// Picker selects and health-tracks the destination candidates a call
+	// may be relayed to. If nil, NewRelayer falls back to relay.NewPicker().
+	Picker *relay.Picker
+
+	// MaxAttempts bounds how many destinations a call will be retried
+	// against before the relay gives up on it. Values <= 0 fall back to 1
+	// (no retries).
+	MaxAttempts int
+}
+
+// RelayMetricsTracer returns the RelayOptions.MetricsTracer configured
+// for ch, or nil if NewRelayer should fall back to a DefaultMetricsTracer.
+func (ch *Channel) RelayMetricsTracer() relay.MetricsTracer {
+	return ch.relayOptions.MetricsTracer
+}
+
+// RelayResources returns the RelayOptions.Resources limits configured for
+// ch, used to build the ResourceTree each of its Relayers enforces.
+func (ch *Channel) RelayResources() relay.Resources {
+	return ch.relayOptions.Resources
+}
+
+// RelayACLFilter returns the RelayOptions.ACLFilter configured for ch, or
+// nil if NewRelayer should allow every call and destination.
+func (ch *Channel) RelayACLFilter() relay.ACLFilter {
+	return ch.relayOptions.ACLFilter
+}
+
+// RelayPicker returns the RelayOptions.Picker configured for ch, or nil if
+// NewRelayer should fall back to relay.NewPicker().
+func (ch *Channel) RelayPicker() *relay.Picker {
+	return ch.relayOptions.Picker
+}
+
+// RelayMaxAttempts returns the RelayOptions.MaxAttempts configured for
+// ch, or 0 if NewRelayer should fall back to its default of 1.
+func (ch *Channel) RelayMaxAttempts() int {
+	return ch.relayOptions.MaxAttempts
+}